@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// TestPlan is the benchmark configuration the leader publishes so that
+// followers drive the same target rates against the same action.
+type TestPlan struct {
+	StartRate   int                    `json:"start_rate"`
+	EndRate     int                    `json:"end_rate"`
+	Step        int                    `json:"step"`
+	RunDuration float64                `json:"run_duration"`
+	ActionName  string                 `json:"action_name"`
+	ReqBody     map[string]interface{} `json:"req_body"`
+}
+
+// InvocationRecord is one load-generator's report of a single invocation,
+// streamed to the coordinator so the leader can aggregate results cross-node.
+type InvocationRecord struct {
+	RID          int    `json:"rid"`
+	ActivationID string `json:"activation_id"`
+	InvokeUs     int64  `json:"invoke_us"`
+	ResultUs     int64  `json:"result_us"`
+	Err          string `json:"err"`
+}
+
+// Coordinator lets several load-generator processes jointly drive a target
+// rate against one OpenWhisk deployment: a single leader owns the test plan,
+// followers shard the id space between themselves, and any node can abort
+// the whole run on error.
+type Coordinator interface {
+	// Campaign blocks until this member is the leader, or ctx is done. If
+	// the underlying session is later lost, the coordinator re-campaigns
+	// in the background on reconnect.
+	Campaign(ctx context.Context) error
+	// IsLeader reports whether this member currently holds leadership.
+	IsLeader() bool
+	// PublishPlan is only valid on the leader; it writes the plan so
+	// followers can pick it up via WatchPlan.
+	PublishPlan(ctx context.Context, plan *TestPlan) error
+	// WatchPlan streams plan updates as they are published.
+	WatchPlan(ctx context.Context) (<-chan TestPlan, error)
+	// Shard returns this member's (shard_index, shard_count) derived from
+	// the live member count so `id % shard_count == shard_index` selects
+	// this member's slice of the request stream. Callers should call this
+	// once per rate step/batch and reuse the result, not once per id -
+	// it is a blocking etcd round trip.
+	Shard(ctx context.Context) (shardIndex, shardCount int, err error)
+	// ReportError CAS-sets the shared error flag so every node aborts.
+	ReportError(ctx context.Context) error
+	// HasError reports whether any member has called ReportError.
+	HasError(ctx context.Context) bool
+	// ReportResult streams a per-invocation record into this member's key range.
+	ReportResult(ctx context.Context, rec InvocationRecord) error
+	// AggregateResults reads every member's reported records back; only
+	// the leader needs to call this at end-of-run.
+	AggregateResults(ctx context.Context) ([]InvocationRecord, error)
+	// Close releases the etcd session/lease and closes the client.
+	Close() error
+}
+
+const (
+	coordMemberPrefix = "/ow-bench/members/"
+	coordPlanKey      = "/ow-bench/plan"
+	coordErrorKey     = "/ow-bench/has_error"
+	coordResultPrefix = "/ow-bench/results/"
+	coordElectionKey  = "/ow-bench/leader"
+	memberLeaseTTL    = 10 // seconds
+)
+
+// EtcdCoordinator implements Coordinator on top of an etcd v3 cluster,
+// the same way dex layers leader election and heartbeats on etcd.
+type EtcdCoordinator struct {
+	cli      *clientv3.Client
+	memberID string
+
+	// mu guards every field below: session/election/leader are replaced
+	// from the background reconnect goroutine while Campaign/IsLeader may
+	// run concurrently on the caller's goroutine; hasError is kept current
+	// by watchErrorFlag's background etcd Watch.
+	mu         sync.Mutex
+	session    *concurrency.Session
+	election   *concurrency.Election
+	leader     bool
+	wantLeader bool // true once Campaign has been called; tells reconnect to re-campaign
+	hasError   bool
+}
+
+// NewEtcdCoordinator dials endpoints and registers this process as a member
+// under coordMemberPrefix with a TTL lease, so live-member count can be
+// derived by listing that prefix.
+func NewEtcdCoordinator(endpoints []string, memberID string) (*EtcdCoordinator, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ec := &EtcdCoordinator{cli: cli, memberID: memberID}
+	if err := ec.reconnect(); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	go ec.watchErrorFlag()
+	return ec, nil
+}
+
+// reconnect (re)establishes the lease-backed session and heartbeat key,
+// and is called again whenever the previous session is lost so a lost
+// lease re-elects rather than leaving two members thinking they lead.
+func (ec *EtcdCoordinator) reconnect() error {
+	session, err := concurrency.NewSession(ec.cli, concurrency.WithTTL(memberLeaseTTL))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ec.cli.Put(ctx, coordMemberPrefix+ec.memberID, "alive", clientv3.WithLease(session.Lease())); err != nil {
+		session.Close()
+		return err
+	}
+
+	ec.mu.Lock()
+	ec.session = session
+	ec.election = concurrency.NewElection(session, coordElectionKey)
+	ec.leader = false
+	wantLeader := ec.wantLeader
+	ec.mu.Unlock()
+
+	go ec.watchSessionLoss(session)
+	if wantLeader {
+		// The previous session's leadership (if any) died with it; try to
+		// re-acquire rather than running un-elected until the process
+		// restarts.
+		go ec.recampaign()
+	}
+	return nil
+}
+
+// watchSessionLoss reconnects when the lease/session dies, e.g. on a
+// network partition, instead of silently running un-elected.
+func (ec *EtcdCoordinator) watchSessionLoss(session *concurrency.Session) {
+	<-session.Done()
+	log.Printf("etcd session lost for member %v, reconnecting...", ec.memberID)
+	backoff := time.Second
+	for {
+		if err := ec.reconnect(); err == nil {
+			log.Printf("etcd session re-established for member %v", ec.memberID)
+			return
+		}
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// recampaign re-runs election on the session reconnect just installed; it
+// is only invoked for members that had previously called Campaign.
+func (ec *EtcdCoordinator) recampaign() {
+	if err := ec.Campaign(context.Background()); err != nil {
+		log.Printf("coordinator: re-campaign failed for member %v: %v", ec.memberID, err)
+	}
+}
+
+func (ec *EtcdCoordinator) Campaign(ctx context.Context) error {
+	ec.mu.Lock()
+	ec.wantLeader = true
+	election := ec.election
+	ec.mu.Unlock()
+
+	if err := election.Campaign(ctx, ec.memberID); err != nil {
+		return err
+	}
+
+	ec.mu.Lock()
+	// Only claim leadership if this is still the election tied to the
+	// live session; a reconnect may have raced us and started a fresh one.
+	if ec.election == election {
+		ec.leader = true
+	}
+	ec.mu.Unlock()
+	return nil
+}
+
+func (ec *EtcdCoordinator) IsLeader() bool {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	return ec.leader
+}
+
+func (ec *EtcdCoordinator) PublishPlan(ctx context.Context, plan *TestPlan) error {
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	_, err = ec.cli.Put(ctx, coordPlanKey, string(body))
+	return err
+}
+
+func (ec *EtcdCoordinator) WatchPlan(ctx context.Context) (<-chan TestPlan, error) {
+	out := make(chan TestPlan)
+	get, err := ec.cli.Get(ctx, coordPlanKey)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(out)
+		if len(get.Kvs) > 0 {
+			var plan TestPlan
+			if err := json.Unmarshal(get.Kvs[0].Value, &plan); err == nil {
+				out <- plan
+			}
+		}
+		watchChan := ec.cli.Watch(ctx, coordPlanKey)
+		for resp := range watchChan {
+			for _, ev := range resp.Events {
+				var plan TestPlan
+				if err := json.Unmarshal(ev.Kv.Value, &plan); err != nil {
+					log.Printf("coordinator: bad plan update: %v", err)
+					continue
+				}
+				out <- plan
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Shard counts live members under coordMemberPrefix (each held up by its
+// own TTL lease) and returns this member's position among them, ordered
+// by key so every member agrees on the same assignment. This is a single
+// etcd round trip; callers must cache the result for a whole rate step
+// rather than calling Shard per request id.
+func (ec *EtcdCoordinator) Shard(ctx context.Context) (int, int, error) {
+	resp, err := ec.cli.Get(ctx, coordMemberPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, 0, err
+	}
+	count := len(resp.Kvs)
+	if count == 0 {
+		return 0, 0, fmt.Errorf("coordinator: no live members under %v", coordMemberPrefix)
+	}
+	myKey := coordMemberPrefix + ec.memberID
+	for i, kv := range resp.Kvs {
+		if string(kv.Key) == myKey {
+			return i, count, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("coordinator: member %v not registered", ec.memberID)
+}
+
+// ReportError CAS-sets coordErrorKey from absent to "1" so only the first
+// failing node performs the write, and every node observing the flag
+// (via HasError) aborts instead of continuing to drive requests.
+func (ec *EtcdCoordinator) ReportError(ctx context.Context) error {
+	_, err := ec.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(coordErrorKey), "=", 0)).
+		Then(clientv3.OpPut(coordErrorKey, "1")).
+		Commit()
+	return err
+}
+
+// HasError returns the last value watchErrorFlag observed for
+// coordErrorKey, not a fresh etcd Get: callers call this once per
+// dispatched request, and a blocking round trip there would serialize the
+// hot dispatch path behind etcd latency.
+func (ec *EtcdCoordinator) HasError(ctx context.Context) bool {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	return ec.hasError
+}
+
+// watchErrorFlag keeps ec.hasError current via a long-lived etcd Watch
+// instead of every HasError call paying for its own Get.
+func (ec *EtcdCoordinator) watchErrorFlag() {
+	resp, err := ec.cli.Get(context.Background(), coordErrorKey)
+	if err != nil {
+		log.Printf("coordinator: initial HasError check failed: %v", err)
+	} else if len(resp.Kvs) > 0 {
+		ec.mu.Lock()
+		ec.hasError = true
+		ec.mu.Unlock()
+	}
+
+	for wresp := range ec.cli.Watch(context.Background(), coordErrorKey) {
+		for _, ev := range wresp.Events {
+			if ev.Type == clientv3.EventTypePut {
+				ec.mu.Lock()
+				ec.hasError = true
+				ec.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (ec *EtcdCoordinator) ReportResult(ctx context.Context, rec InvocationRecord) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%v%v/%v", coordResultPrefix, ec.memberID, rec.RID)
+	_, err = ec.cli.Put(ctx, key, string(body))
+	return err
+}
+
+// AggregateResults lists every key under coordResultPrefix (across every
+// member's sub-range) and decodes them back into InvocationRecords, so
+// the leader can report cross-node totals at the end of a run.
+func (ec *EtcdCoordinator) AggregateResults(ctx context.Context) ([]InvocationRecord, error) {
+	resp, err := ec.cli.Get(ctx, coordResultPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]InvocationRecord, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var rec InvocationRecord
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			log.Printf("coordinator: bad result record at %v: %v", string(kv.Key), err)
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+func (ec *EtcdCoordinator) Close() error {
+	ec.mu.Lock()
+	session := ec.session
+	ec.mu.Unlock()
+	if session != nil {
+		session.Close()
+	}
+	return ec.cli.Close()
+}
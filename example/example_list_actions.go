@@ -18,6 +18,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -28,6 +29,9 @@ import (
 	"time"
 
 	"github.com/apache/openwhisk-client-go/whisk"
+	"github.com/gorilla/websocket"
+	"github.com/hugy718/openwhisk-client-go-ex/example/metrics"
+	"github.com/hugy718/openwhisk-client-go-ex/example/workload"
 )
 
 type UserRequest struct {
@@ -58,12 +62,30 @@ type ServerlessClient interface {
 	Init() error
 	CreateAction(name, kind, image string, concurrency int) error
 	InvokeAction(name string, req *UserRequest, rid int) (string, string, error)
-	GetResult(rid int, resp_id string) (string, error)
+	// GetResult blocks for up to timeout waiting for resp_id's activation
+	// to complete; timeout <= 0 falls back to a client-chosen default.
+	GetResult(rid int, resp_id string, timeout time.Duration) (string, error)
 	DeleteAction(name string) error
+	// SubscribeResults streams activation records as they complete, off the
+	// same activation feed GetResult waits on when UseEventFeed is set.
+	SubscribeResults() (<-chan ActivationRecord, error)
 }
 
 type OwClient struct {
 	cli *whisk.Client
+
+	// UseEventFeed selects the event-driven result path (the activation
+	// feed) over the default 18-try/2s polling loop. Deployments without the
+	// activation-events feed should leave this false.
+	UseEventFeed bool
+	// MaxRespBodyBufferSize caps a single feed frame; 0 means
+	// defaultMaxRespBodyBufferSize (64KB).
+	MaxRespBodyBufferSize int
+
+	feedMu    sync.Mutex
+	feedConn  *websocket.Conn
+	pending   map[string]chan ActivationRecord
+	broadcast chan ActivationRecord
 }
 
 // thread safe
@@ -92,11 +114,33 @@ func (oc *OwClient) InvokeAction(n string, r *UserRequest, rid int) (string, str
 	log.Printf("invoke (%v at %v)", rid, time.Now().UnixMicro())
 	wskresp, resp, err := oc.cli.Actions.Invoke(n, r.OwBodySerialize(), false, false)
 	log.Printf("invoke response (%v at %v): %v", rid, time.Now().UnixMicro(), resp)
-	resp_id := fmt.Sprintf("%v", wskresp["activationId"])
+	resp_id := ""
+	if m, ok := wskresp.(map[string]interface{}); ok {
+		resp_id = fmt.Sprintf("%v", m["activationId"])
+	}
+	if oc.UseEventFeed && err == nil {
+		if ferr := oc.ensureFeed(); ferr != nil {
+			log.Printf("invoke (%v): event feed unavailable, falling back to polling: %v", rid, ferr)
+		} else {
+			oc.registerPending(resp_id)
+		}
+	}
 	return resp_id, fmt.Sprintf("invoke response: %v", resp), err
 }
 
-func (oc *OwClient) GetResult(rid int, resp_id string) (string, error) {
+// GetResult blocks until resp_id's activation completes, or until timeout
+// elapses (timeout <= 0 uses defaultResultTimeout). With UseEventFeed it
+// waits on the channel InvokeAction registered against the activation
+// feed; otherwise it falls back to the fixed 18-try/2s polling loop, which
+// ignores timeout since its retry budget is fixed.
+func (oc *OwClient) GetResult(rid int, resp_id string, timeout time.Duration) (string, error) {
+	if oc.UseEventFeed {
+		return oc.getResultFromFeed(rid, resp_id, timeout)
+	}
+	return oc.getResultByPolling(rid, resp_id)
+}
+
+func (oc *OwClient) getResultByPolling(rid int, resp_id string) (string, error) {
 	// log.Printf("get response : %v", resp_id)
 	_, resp, err := oc.cli.Activations.Get(resp_id)
 	retry_count := 18
@@ -132,22 +176,151 @@ func handle_error(err error) bool {
 	return true
 }
 
+// resolve_shard obtains this node's (shard_index, shard_count) once before
+// generating ids: Shard() is a blocking etcd round trip, so it must not be
+// called per request id inside the hot loop.
+func resolve_shard(ctx *BenchmarkCtx) (shard_index, shard_count int) {
+	if ctx.coord == nil {
+		return 0, 1
+	}
+	shard_index, shard_count, err := ctx.coord.Shard(context.Background())
+	if err != nil {
+		log.Printf("coordinator: Shard failed, driving all ids locally: %v", err)
+		return 0, 1
+	}
+	return shard_index, shard_count
+}
+
+// in_shard reports whether id belongs to this node's slice of the request
+// stream, given the (shard_index, shard_count) resolve_shard returned for
+// the current batch.
+func in_shard(id, shard_index, shard_count int) bool {
+	return id%shard_count == shard_index
+}
+
+// report_invocation streams the invocation's outcome to the coordinator so
+// the leader can aggregate cross-node results at end-of-run; a no-op when
+// ctx is not coordinated.
+func report_invocation(ctx *BenchmarkCtx, id int, resp_id string, invoke_us, result_us int64, err error) {
+	if ctx.coord == nil {
+		return
+	}
+	if rerr := ctx.coord.ReportResult(context.Background(), InvocationRecord{
+		RID: id, ActivationID: resp_id, InvokeUs: invoke_us, ResultUs: result_us, Err: errString(err),
+	}); rerr != nil {
+		log.Printf("coordinator: ReportResult failed: %v", rerr)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// aggregate_results, run by the leader at the end of a rate step, reads
+// every member's reported records back and logs a cross-node summary.
+func aggregate_results(ctx *BenchmarkCtx) {
+	if ctx.coord == nil || !ctx.coord.IsLeader() {
+		return
+	}
+	recs, err := ctx.coord.AggregateResults(context.Background())
+	if err != nil {
+		log.Printf("coordinator: AggregateResults failed: %v", err)
+		return
+	}
+	errs := 0
+	for _, r := range recs {
+		if r.Err != "" {
+			errs++
+		}
+	}
+	log.Printf("coordinator: aggregated %v cross-node results (%v errors)", len(recs), errs)
+}
+
+// defaultWarmUpStep is the warm_up_step passed to benchmark_step_warm_lat
+// for a coordinated run; TestPlan doesn't carry one of its own, so every
+// member ramps up the same way regardless of who published the plan.
+const defaultWarmUpStep = 2
+
+// run_coordinated_plan makes every member of a coordinated run drive the
+// same TestPlan instead of each one running its own hard-coded
+// action/rate/body: the leader builds a plan from ctx's action name and
+// request body, with a fixed ramp of 2..20 req/s in steps of 2 for 1 minute
+// each, and publishes it; followers pick it up via WatchPlan, and
+// every member (leader included) then runs benchmark_step_warm_lat against
+// the agreed plan.
+func run_coordinated_plan(ctx *BenchmarkCtx) error {
+	var plan TestPlan
+	if ctx.coord.IsLeader() {
+		plan = TestPlan{
+			StartRate: 2, EndRate: 20, Step: 2, RunDuration: 1,
+			ActionName: ctx.action_name, ReqBody: ctx.req.OwBodySerialize(),
+		}
+		if err := ctx.coord.PublishPlan(context.Background(), &plan); err != nil {
+			return fmt.Errorf("coordinator: PublishPlan failed: %w", err)
+		}
+	} else {
+		plans, err := ctx.coord.WatchPlan(context.Background())
+		if err != nil {
+			return fmt.Errorf("coordinator: WatchPlan failed: %w", err)
+		}
+		plan = <-plans
+	}
+
+	body, err := json.Marshal(plan.ReqBody)
+	if err != nil {
+		return fmt.Errorf("coordinator: bad plan request body: %w", err)
+	}
+	req := UserRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("coordinator: bad plan request body: %w", err)
+	}
+	ctx.action_name = plan.ActionName
+	ctx.req = &req
+
+	benchmark_step_warm_lat(plan.StartRate, plan.EndRate, plan.Step, plan.RunDuration, defaultWarmUpStep, ctx)
+	return nil
+}
+
 func send_con_req(num int, ctx *BenchmarkCtx) {
+	shard_index, shard_count := resolve_shard(ctx)
+
 	var wg sync.WaitGroup
 	for count := 0; count < num; count += 1 {
+		if !in_shard(count, shard_index, shard_count) {
+			continue
+		}
 		wg.Add(1)
 
 		go func(id int) {
 			defer wg.Done()
+			send_us := time.Now().UnixMicro()
 			resp_id, _, err := ctx.cli.InvokeAction(ctx.action_name, ctx.req, id)
+			ack_us := time.Now().UnixMicro()
 			if err == nil {
-				_, err = ctx.cli.GetResult(id, resp_id)
+				_, err = ctx.cli.GetResult(id, resp_id, ctx.result_timeout)
 			}
+			result_us := time.Now().UnixMicro()
 			handle_error(err)
+			if ctx.metrics != nil {
+				ctx.metrics.Record(metrics.Record{
+					RID: id, ActionName: ctx.action_name, TargetRate: num,
+					InvokeSendUs: send_us, InvokeAckUs: ack_us - send_us,
+					ActivationID: resp_id, ResultUs: result_us - send_us, Err: err,
+				})
+			}
+			report_invocation(ctx, id, resp_id, ack_us-send_us, result_us-send_us, err)
+			if err != nil && ctx.coord != nil {
+				if rerr := ctx.coord.ReportError(context.Background()); rerr != nil {
+					log.Printf("coordinator: ReportError failed: %v", rerr)
+				}
+			}
 		}(count)
 	}
 	wg.Wait()
-
+	aggregate_results(ctx)
 }
 
 // warm_up gradually increase invoke rate to the target
@@ -166,6 +339,22 @@ type BenchmarkCtx struct {
 	cli         ServerlessClient
 	action_name string
 	req         *UserRequest
+	// coord is nil for a standalone run; when set, the id stream is
+	// sharded across the live members of the coordinated run and a
+	// failure on any node aborts all of them.
+	coord Coordinator
+	// metrics is nil to skip recording; when set, every invocation in
+	// send_con_req/benchmark_step_warm_lat/benchmark_warm_at_fixed_provision
+	// is folded into its (action_name, target_rate) histogram instead of
+	// only being logged.
+	metrics *metrics.Aggregator
+	// slo_us stops benchmark_step_warm_lat after the first rate step whose
+	// p99 end-to-end latency exceeds it, skipping the remaining steps; the
+	// check runs once the step has finished. 0 disables the check.
+	slo_us int64
+	// result_timeout bounds how long GetResult waits on the event feed
+	// before giving up; 0 means defaultResultTimeout.
+	result_timeout time.Duration
 }
 
 // benchmark system warm latency
@@ -186,37 +375,64 @@ func benchmark_step_warm_lat(start_rate, end_rate, step int, run_duration float6
 		warm_up(cur_rate, warm_up_step, target_rate, ctx)
 		cur_rate = target_rate
 		log.Printf("testing at rate: %d\n", target_rate)
-		id := 0
-		var wg sync.WaitGroup
-		inter_arrival := 1.0 / float64(target_rate)
-		start_time := time.Now().UnixMicro()
-		for t := 0.0; t < run_duration*60.0; t += inter_arrival {
-			wg.Add(1)
-			now := time.Now().UnixMicro()
-			if start_time+int64(t*1e6) > now {
-				sleep_duration := start_time + int64(t*1e6) - now
-				time.Sleep(time.Duration(sleep_duration) * time.Microsecond)
+		shard_index, shard_count := resolve_shard(ctx)
+		gen := workload.NewConstantRate(target_rate, run_duration*60.0)
+		pool := workload.NewPool(0)
+		for {
+			if has_error > 0 || (ctx.coord != nil && ctx.coord.HasError(context.Background())) {
+				break
 			}
-			go func(id int) {
-				defer wg.Done()
+			id, skew, ok := gen.Next()
+			if !ok {
+				break
+			}
+			if !in_shard(id, shard_index, shard_count) {
+				continue
+			}
+			skew_us := int64(skew / time.Microsecond)
+			pool.Submit(func() {
+				send_us := time.Now().UnixMicro()
 				resp_id, _, err := ctx.cli.InvokeAction(ctx.action_name, ctx.req, id)
+				ack_us := time.Now().UnixMicro()
 				if err == nil {
-					_, err = ctx.cli.GetResult(id, resp_id)
+					_, err = ctx.cli.GetResult(id, resp_id, ctx.result_timeout)
 				}
+				result_us := time.Now().UnixMicro()
 				handle_error(err)
+				if ctx.metrics != nil {
+					ctx.metrics.Record(metrics.Record{
+						RID: id, ActionName: ctx.action_name, TargetRate: target_rate,
+						InvokeSendUs: send_us, InvokeAckUs: ack_us - send_us,
+						ActivationID: resp_id, ResultUs: result_us - send_us, Err: err,
+						SkewUs: skew_us,
+					})
+				}
+				report_invocation(ctx, id, resp_id, ack_us-send_us, result_us-send_us, err)
 				if err != nil {
 					atomic.StoreInt32(&has_error, 1)
+					if ctx.coord != nil {
+						if rerr := ctx.coord.ReportError(context.Background()); rerr != nil {
+							log.Printf("coordinator: ReportError failed: %v", rerr)
+						}
+					}
 				}
-			}(id)
-			id++
-			// skip subsequent requests
-			if has_error > 0 {
-				break
+			})
+		}
+		pool.Wait()
+
+		if ctx.metrics != nil {
+			s := ctx.metrics.Snapshot(ctx.action_name, target_rate)
+			log.Printf("rate %v: e2e p50=%vus p90=%vus p99=%vus p999=%vus max=%vus | ack p50=%vus p90=%vus p99=%vus p999=%vus max=%vus | error_rate=%.4f avg_skew=%vus",
+				target_rate, s.P50Us, s.P90Us, s.P99Us, s.P999Us, s.MaxUs,
+				s.InvokeAckP50Us, s.InvokeAckP90Us, s.InvokeAckP99Us, s.InvokeAckP999Us, s.InvokeAckMaxUs, s.ErrorRate, s.AvgSkewUs)
+			if s.ExceedsSLO(ctx.slo_us) {
+				log.Printf("rate %v: p99 %vus exceeds SLO %vus, aborting", target_rate, s.P99Us, ctx.slo_us)
+				atomic.StoreInt32(&has_error, 1)
 			}
 		}
-		wg.Wait()
+		aggregate_results(ctx)
 		// skip later rounds
-		if has_error > 0 {
+		if has_error > 0 || (ctx.coord != nil && ctx.coord.HasError(context.Background())) {
 			break
 		}
 	}
@@ -294,7 +510,7 @@ func main() {
 	// 			resp_id, _, err := client.InvokeAction(action_name, &req, id)
 	// 			if err == nil {
 	// 				log.Printf("activation id: %v", resp_id)
-	// 				_, err = client.GetResult(id, resp_id)
+	// 				_, err = client.GetResult(id, resp_id, 0)
 	// 			}
 	// 			handle_error(err)
 	// 			if err != nil {
@@ -305,7 +521,41 @@ func main() {
 	// 	wg.Wait()
 	// }
 
-	ctx := BenchmarkCtx{&client, action_name, &req}
+	// event feed: turn on only against a deployment with the
+	// activation-events feed enabled; defaults to the polling GetResult.
+	// client.UseEventFeed = true
+
+	// metrics: on by default, written to bench_metrics.jsonl for offline
+	// analysis; percentiles/error-rate are also logged at the end of each
+	// rate step.
+	jsonlReporter, err := metrics.NewJSONLReporter("bench_metrics.jsonl")
+	if handle_error(err) {
+		os.Exit(-1)
+	}
+	agg := metrics.NewAggregator(jsonlReporter)
+	defer agg.Close()
+
+	// multi-node coordination: point at a shared etcd cluster to shard the
+	// id stream across several load-generator processes. One node should
+	// Campaign for leadership; the leader publishes the TestPlan and every
+	// node (leader included) then drives it via run_coordinated_plan.
+	// coord, err := NewEtcdCoordinator([]string{"localhost:2379"}, "node-1")
+	// if handle_error(err) {
+	// 	os.Exit(-1)
+	// }
+	// defer coord.Close()
+	// if handle_error(coord.Campaign(context.Background())) {
+	// 	os.Exit(-1)
+	// }
+
+	ctx := BenchmarkCtx{cli: &client, action_name: action_name, req: &req, metrics: agg}
+	// ctx.coord = coord
+	// if ctx.coord != nil {
+	// 	if handle_error(run_coordinated_plan(&ctx)) {
+	// 		os.Exit(-1)
+	// 	}
+	// 	return
+	// }
 	benchmark_warm_at_fixed_provision(20, 2, &ctx)
 
 	log.Print("switch testing to crtw-tflm--mb-1")
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdReporter writes per-(action, rate) summaries under prefix so the
+// benchmark Coordinator's leader can aggregate cross-node results at the
+// end of a run; it ignores individual records and only reacts to Snapshot.
+type EtcdReporter struct {
+	cli      *clientv3.Client
+	prefix   string
+	memberID string
+}
+
+// NewEtcdReporter dials endpoints and writes summaries under
+// prefix+memberID+"/"+action+"/"+rate.
+func NewEtcdReporter(endpoints []string, prefix, memberID string) (*EtcdReporter, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdReporter{cli: cli, prefix: prefix, memberID: memberID}, nil
+}
+
+func (e *EtcdReporter) ReportRecord(rec Record) error { return nil }
+
+func (e *EtcdReporter) ReportSummary(s Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%v%v/%v/%v", e.prefix, e.memberID, s.ActionName, s.TargetRate)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = e.cli.Put(ctx, key, string(body))
+	return err
+}
+
+func (e *EtcdReporter) Close() error {
+	return e.cli.Close()
+}
@@ -0,0 +1,325 @@
+// Package metrics collects per-invocation benchmark records into HDR
+// histograms and exposes them through pluggable Reporter sinks, replacing
+// the ad-hoc log.Printf lines the benchmark loops used to rely on.
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// histogram range: 1us .. 600s at 3 significant digits, wide enough to
+// cover a stalled cold-start without overflowing.
+const (
+	histMinUs  = int64(1)
+	histMaxUs  = int64(600 * 1e6)
+	histSigFig = 3
+)
+
+// Record is one completed (or failed) invocation.
+type Record struct {
+	RID          int
+	ActionName   string
+	TargetRate   int
+	InvokeSendUs int64
+	InvokeAckUs  int64
+	ActivationID string
+	ResultUs     int64
+	Err          error
+	// SkewUs is how far this request's dispatch lagged its scheduled
+	// arrival time (the skew workload.Generator.Next returns); non-zero means the
+	// sample arrived late and its latency should be viewed with suspicion.
+	SkewUs int64
+}
+
+// key groups records the way the benchmark loops step through rates: one
+// histogram pair per (action, target rate).
+type key struct {
+	action string
+	rate   int
+}
+
+type bucket struct {
+	invokeAck *hdrhistogram.Histogram
+	e2e       *hdrhistogram.Histogram
+	total     int64
+	errors    int64
+	skewSumUs int64
+}
+
+func newBucket() *bucket {
+	return &bucket{
+		invokeAck: hdrhistogram.New(histMinUs, histMaxUs, histSigFig),
+		e2e:       hdrhistogram.New(histMinUs, histMaxUs, histSigFig),
+	}
+}
+
+// Summary is a point-in-time read of one bucket's latency distribution.
+type Summary struct {
+	ActionName string
+	TargetRate int
+	Total      int64
+	ErrorRate  float64
+	P50Us      int64
+	P90Us      int64
+	P99Us      int64
+	P999Us     int64
+	MaxUs      int64
+	// Invoke-ack percentiles: time from send to the invoke call returning
+	// an activation id, as opposed to the end-to-end P*Us fields above.
+	InvokeAckP50Us  int64
+	InvokeAckP90Us  int64
+	InvokeAckP99Us  int64
+	InvokeAckP999Us int64
+	InvokeAckMaxUs  int64
+	// AvgSkewUs is the mean achieved-vs-target dispatch lag for this
+	// bucket; a rising value means the workload generator fell behind
+	// schedule and later samples here should not be trusted as true
+	// target-rate latency.
+	AvgSkewUs int64
+}
+
+// Reporter is a sink that a completed Record (or periodic Summary) can be
+// pushed to; implementations should not block the caller for long.
+type Reporter interface {
+	ReportRecord(rec Record) error
+	ReportSummary(s Summary) error
+	Close() error
+}
+
+// Aggregator is the per-process metrics store the benchmark loops write
+// into instead of log.Printf; its Reporters are passed to NewAggregator.
+type Aggregator struct {
+	mu        sync.Mutex
+	buckets   map[key]*bucket
+	reporters []Reporter
+}
+
+// NewAggregator returns an empty Aggregator with the given sinks attached.
+func NewAggregator(reporters ...Reporter) *Aggregator {
+	return &Aggregator{
+		buckets:   make(map[key]*bucket),
+		reporters: reporters,
+	}
+}
+
+// Record folds one invocation into its (action, rate) bucket and forwards
+// the raw record to every attached Reporter.
+func (a *Aggregator) Record(rec Record) {
+	k := key{rec.ActionName, rec.TargetRate}
+
+	a.mu.Lock()
+	b, ok := a.buckets[k]
+	if !ok {
+		b = newBucket()
+		a.buckets[k] = b
+	}
+	b.total++
+	b.skewSumUs += rec.SkewUs
+	if rec.Err != nil {
+		b.errors++
+	} else {
+		_ = b.invokeAck.RecordValue(rec.InvokeAckUs)
+		_ = b.e2e.RecordValue(rec.ResultUs)
+	}
+	a.mu.Unlock()
+
+	for _, r := range a.reporters {
+		if err := r.ReportRecord(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics: report record failed: %v\n", err)
+		}
+	}
+}
+
+// Snapshot computes p50/p90/p99/p999/max and error rate for one bucket's
+// invoke-ack and end-to-end latency histograms, and forwards it to every
+// attached Reporter. The histogram reads happen under the same lock
+// Record takes, since hdrhistogram.Histogram is not safe for concurrent
+// read/write.
+func (a *Aggregator) Snapshot(actionName string, targetRate int) Summary {
+	a.mu.Lock()
+	b, ok := a.buckets[key{actionName, targetRate}]
+	var s Summary
+	if !ok {
+		s = Summary{ActionName: actionName, TargetRate: targetRate}
+	} else {
+		s = Summary{
+			ActionName:      actionName,
+			TargetRate:      targetRate,
+			Total:           b.total,
+			P50Us:           b.e2e.ValueAtQuantile(50),
+			P90Us:           b.e2e.ValueAtQuantile(90),
+			P99Us:           b.e2e.ValueAtQuantile(99),
+			P999Us:          b.e2e.ValueAtQuantile(99.9),
+			MaxUs:           b.e2e.Max(),
+			InvokeAckP50Us:  b.invokeAck.ValueAtQuantile(50),
+			InvokeAckP90Us:  b.invokeAck.ValueAtQuantile(90),
+			InvokeAckP99Us:  b.invokeAck.ValueAtQuantile(99),
+			InvokeAckP999Us: b.invokeAck.ValueAtQuantile(99.9),
+			InvokeAckMaxUs:  b.invokeAck.Max(),
+		}
+		if b.total > 0 {
+			s.ErrorRate = float64(b.errors) / float64(b.total)
+			s.AvgSkewUs = b.skewSumUs / b.total
+		}
+	}
+	a.mu.Unlock()
+
+	for _, r := range a.reporters {
+		if err := r.ReportSummary(s); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics: report summary failed: %v\n", err)
+		}
+	}
+	return s
+}
+
+// ExceedsSLO reports whether s.P99Us is over sloUs, the signal the
+// benchmark loops use after a finished rate step to skip the remaining
+// steps, instead of only aborting on hard errors.
+func (s Summary) ExceedsSLO(sloUs int64) bool {
+	return sloUs > 0 && s.P99Us > sloUs
+}
+
+// Close closes every attached Reporter, returning the first error.
+func (a *Aggregator) Close() error {
+	var first error
+	for _, r := range a.reporters {
+		if err := r.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// JSONLReporter appends one JSON object per line to a file, for offline
+// analysis with jq/pandas.
+type JSONLReporter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// NewJSONLReporter opens (creating/truncating) path for JSON-lines output.
+func NewJSONLReporter(path string) (*JSONLReporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLReporter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (j *JSONLReporter) ReportRecord(rec Record) error {
+	line := struct {
+		RID          int    `json:"rid"`
+		ActionName   string `json:"action_name"`
+		TargetRate   int    `json:"target_rate"`
+		InvokeSendUs int64  `json:"invoke_send_us"`
+		InvokeAckUs  int64  `json:"invoke_ack_us"`
+		ActivationID string `json:"activation_id"`
+		ResultUs     int64  `json:"result_us"`
+		Err          string `json:"err,omitempty"`
+		SkewUs       int64  `json:"skew_us,omitempty"`
+	}{rec.RID, rec.ActionName, rec.TargetRate, rec.InvokeSendUs, rec.InvokeAckUs, rec.ActivationID, rec.ResultUs, errString(rec.Err), rec.SkewUs}
+
+	body, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.w.Write(body); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *JSONLReporter) ReportSummary(s Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.w.Write(body); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *JSONLReporter) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	return j.f.Close()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// PromReporter serves /metrics over HTTP, exporting
+// openwhisk_bench_latency_seconds as a native histogram and
+// openwhisk_bench_errors_total as a counter.
+type PromReporter struct {
+	latency *prometheus.HistogramVec
+	errors  *prometheus.CounterVec
+	srv     *http.Server
+}
+
+// NewPromReporter registers the benchmark's metrics and starts serving
+// addr (e.g. ":9090") in the background.
+func NewPromReporter(addr string) *PromReporter {
+	p := &PromReporter{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                            "openwhisk_bench_latency_seconds",
+			Help:                            "End-to-end invoke+result latency, per action and target rate.",
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  160,
+			NativeHistogramMinResetDuration: 0,
+		}, []string{"action_name", "target_rate"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "openwhisk_bench_errors_total",
+			Help: "Invocations that returned an error.",
+		}, []string{"action_name", "target_rate"}),
+	}
+	prometheus.MustRegister(p.latency, p.errors)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	p.srv = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := p.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "metrics: prometheus server stopped: %v\n", err)
+		}
+	}()
+	return p
+}
+
+func (p *PromReporter) ReportRecord(rec Record) error {
+	if rec.Err != nil {
+		p.errors.WithLabelValues(rec.ActionName, fmt.Sprintf("%d", rec.TargetRate)).Inc()
+		return nil
+	}
+	p.latency.WithLabelValues(rec.ActionName, fmt.Sprintf("%d", rec.TargetRate)).Observe(float64(rec.ResultUs) / 1e6)
+	return nil
+}
+
+func (p *PromReporter) ReportSummary(s Summary) error { return nil }
+
+func (p *PromReporter) Close() error {
+	return p.srv.Close()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apache/openwhisk-client-go/whisk"
+	"github.com/gorilla/websocket"
+)
+
+// ActivationRecord is one message off the controller's activation-events
+// feed, demultiplexed by activation id to the caller that is waiting on it.
+type ActivationRecord struct {
+	ActivationID string
+	Result       string
+	Err          error
+}
+
+// defaultResultTimeout bounds how long GetResult waits on the event feed
+// before giving up, mirroring the old polling loop's overall budget
+// (18 tries * 2s).
+const defaultResultTimeout = 36 * time.Second
+
+// defaultMaxRespBodyBufferSize is the read-buffer ceiling for a single feed
+// frame, set the same way grpc-websocket-proxy's WithMaxRespBodyBufferSize
+// lets callers raise the buffer for responses larger than the 64KB default.
+const defaultMaxRespBodyBufferSize = 64 * 1024
+
+// SubscribeResults opens a long-lived connection to the controller's
+// activation-events feed and returns a channel that receives every
+// activation record as it arrives. InvokeAction/GetResult use the same
+// feed internally to avoid the old fixed-retry polling loop.
+func (oc *OwClient) SubscribeResults() (<-chan ActivationRecord, error) {
+	if err := oc.ensureFeed(); err != nil {
+		return nil, err
+	}
+	return oc.broadcast, nil
+}
+
+// ensureFeed dials the activation feed once and starts the demux loop;
+// later calls are no-ops while the connection is live.
+func (oc *OwClient) ensureFeed() error {
+	oc.feedMu.Lock()
+	defer oc.feedMu.Unlock()
+	if oc.feedConn != nil {
+		return nil
+	}
+	if oc.pending == nil {
+		oc.pending = make(map[string]chan ActivationRecord)
+	}
+	if oc.broadcast == nil {
+		oc.broadcast = make(chan ActivationRecord, 64)
+	}
+
+	maxBuf := oc.MaxRespBodyBufferSize
+	if maxBuf <= 0 {
+		maxBuf = defaultMaxRespBodyBufferSize
+	}
+
+	feedURL := feedURLFromConfig(oc.cli.Config)
+	conn, _, err := websocket.DefaultDialer.Dial(feedURL, authHeader(oc.cli.Config))
+	if err != nil {
+		return fmt.Errorf("subscribe: dial activation feed %v: %w", feedURL, err)
+	}
+	// ReadBufferSize is an I/O buffer, not a message-size cap; SetReadLimit
+	// is what actually bounds (or raises, for payloads over the 64KB
+	// default) a single frame's size.
+	conn.SetReadLimit(int64(maxBuf))
+	oc.feedConn = conn
+
+	go oc.demuxFeed(conn)
+	return nil
+}
+
+// authHeader builds the same basic-auth header the REST client sends, so
+// the feed dial is accepted by a real deployment instead of being
+// rejected for having no credentials at all.
+func authHeader(cfg *whisk.Config) http.Header {
+	token := base64.StdEncoding.EncodeToString([]byte(cfg.AuthToken))
+	return http.Header{"Authorization": []string{"Basic " + token}}
+}
+
+// feedURLFromConfig derives the controller's activation-events websocket
+// endpoint from the same whisk.Config used for REST calls.
+func feedURLFromConfig(cfg *whisk.Config) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(cfg.Host, "https://"), "http://")
+	return fmt.Sprintf("wss://%v/api/v1/namespaces/%v/activations/feed", host, cfg.Namespace)
+}
+
+// demuxFeed reads activation-events frames and routes each one to the
+// caller blocked in GetResult, plus a copy to every SubscribeResults reader.
+func (oc *OwClient) demuxFeed(conn *websocket.Conn) {
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("subscribe: feed read failed: %v", err)
+			oc.closeFeed()
+			return
+		}
+		var msg struct {
+			ActivationID string `json:"activationId"`
+			Response     string `json:"response"`
+			Error        string `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			log.Printf("subscribe: bad feed frame: %v", err)
+			continue
+		}
+		rec := ActivationRecord{ActivationID: msg.ActivationID, Result: msg.Response}
+		if msg.Error != "" {
+			rec.Err = fmt.Errorf("%v", msg.Error)
+		}
+
+		oc.feedMu.Lock()
+		waiter, ok := oc.pending[rec.ActivationID]
+		if ok {
+			delete(oc.pending, rec.ActivationID)
+		}
+		oc.feedMu.Unlock()
+		if ok {
+			waiter <- rec
+		}
+
+		select {
+		case oc.broadcast <- rec:
+		default:
+			log.Printf("subscribe: broadcast channel full, dropping record for %v", rec.ActivationID)
+		}
+	}
+}
+
+func (oc *OwClient) closeFeed() {
+	oc.feedMu.Lock()
+	defer oc.feedMu.Unlock()
+	if oc.feedConn != nil {
+		oc.feedConn.Close()
+		oc.feedConn = nil
+	}
+}
+
+// registerPending installs the channel a later GetResult call will block
+// on, before InvokeAction returns so no feed frame can race ahead of it.
+func (oc *OwClient) registerPending(resp_id string) chan ActivationRecord {
+	oc.feedMu.Lock()
+	defer oc.feedMu.Unlock()
+	if oc.pending == nil {
+		oc.pending = make(map[string]chan ActivationRecord)
+	}
+	ch := make(chan ActivationRecord, 1)
+	oc.pending[resp_id] = ch
+	return ch
+}
+
+// getResultFromFeed blocks on the channel InvokeAction registered for
+// resp_id, instead of the fixed 18-try/2s polling loop, so end-to-end
+// latency is no longer floored by the poll interval. timeout <= 0 falls
+// back to defaultResultTimeout.
+func (oc *OwClient) getResultFromFeed(rid int, resp_id string, timeout time.Duration) (string, error) {
+	oc.feedMu.Lock()
+	ch, ok := oc.pending[resp_id]
+	oc.feedMu.Unlock()
+	if !ok {
+		// InvokeAction didn't register us (e.g. UseEventFeed flipped on
+		// after invoke); fall back to polling rather than hang forever.
+		return oc.getResultByPolling(rid, resp_id)
+	}
+	if timeout <= 0 {
+		timeout = defaultResultTimeout
+	}
+
+	select {
+	case rec := <-ch:
+		log.Printf("invoke response (%v at %v): %v", rid, time.Now().UnixMicro(), rec.Result)
+		return fmt.Sprintf("invoke response: %v", rec.Result), rec.Err
+	case <-time.After(timeout):
+		oc.feedMu.Lock()
+		delete(oc.pending, resp_id)
+		oc.feedMu.Unlock()
+		return "", fmt.Errorf("subscribe: timed out waiting for activation %v after %v", resp_id, timeout)
+	}
+}
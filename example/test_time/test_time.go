@@ -2,33 +2,23 @@ package main
 
 import (
 	"log"
-	"sync"
 	"time"
+
+	"github.com/hugy718/openwhisk-client-go-ex/example/workload"
 )
 
 // rate: req/s
 // duration: mins
 func test_time(rate int, duration float64) {
-	id := 0
-	var wg sync.WaitGroup
-	inter_arrival := 1.0 / float64(rate)
-	start_time := time.Now().UnixMicro()
-	for t := 0.0; t < duration*60.0; t += inter_arrival {
-		wg.Add(1)
-		now := time.Now().UnixMicro()
-		if start_time+int64(t*1e6) > now {
-			sleep_duration := start_time + int64(t*1e6) - now
-			time.Sleep(time.Duration(sleep_duration) * time.Microsecond)
-			log.Printf("sleep time: %v", sleep_duration)
+	gen := workload.NewConstantRate(rate, duration*60.0)
+	pool := workload.NewPool(0)
+	workload.Dispatch(gen, pool, func(id int, skew time.Duration) {
+		if skew > 0 {
+			log.Printf("id %v: falling behind schedule by %v", id, skew)
 		}
-		go func(id int) {
-			defer wg.Done()
-			log.Printf("echo time: %v", time.Now().UnixMicro())
-			time.Sleep(time.Duration(1 * time.Second))
-		}(id)
-		id++
-	}
-	wg.Wait()
+		log.Printf("echo time: %v", time.Now().UnixMicro())
+		time.Sleep(time.Duration(1 * time.Second))
+	})
 }
 
 func main() {
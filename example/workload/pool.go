@@ -0,0 +1,61 @@
+package workload
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultPoolSize is used when NewPool is called with size <= 0: large
+// enough that a slow invoke doesn't stall the whole run, small enough
+// that the harness itself doesn't become the bottleneck at high rates.
+var DefaultPoolSize = runtime.GOMAXPROCS(0) * 32
+
+// Pool is a bounded worker pool: Submit blocks once size workers are busy,
+// instead of the unbounded `go func(id)` spawn the benchmark loops used to
+// do per request.
+type Pool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+// NewPool returns a Pool that runs at most size tasks concurrently; size
+// <= 0 falls back to DefaultPoolSize.
+func NewPool(size int) *Pool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	return &Pool{sem: make(chan struct{}, size)}
+}
+
+// Submit blocks until a worker slot is free, then runs fn in it.
+func (p *Pool) Submit(fn func()) {
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+		fn()
+	}()
+}
+
+// Wait blocks until every submitted task has returned.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// Dispatch drains gen, submitting fn(id, skew) to pool for every arrival,
+// and waits for them all to finish before returning. skew is the value
+// Next returned for that id, captured on the dispatch goroutine before
+// fn runs in the pool - workers must not call back into gen, which is not
+// safe for concurrent use.
+func Dispatch(gen Generator, pool *Pool, fn func(id int, skew time.Duration)) {
+	for {
+		id, skew, ok := gen.Next()
+		if !ok {
+			break
+		}
+		pool.Submit(func() { fn(id, skew) })
+	}
+	pool.Wait()
+}
@@ -0,0 +1,247 @@
+// Package workload generates open-loop request arrival times, replacing the
+// arrival-time loop that used to be duplicated in test_time and
+// benchmark_step_warm_lat.
+package workload
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Generator produces the dispatch times for an open-loop workload: each
+// call to Next blocks (by sleeping) until the next request is due and
+// returns its id and the schedule skew observed at dispatch time, or
+// ok=false once the generator is exhausted. Skew is returned alongside id
+// rather than read back separately, since Next is called from a single
+// dispatch goroutine while id's work may run on another.
+type Generator interface {
+	Next() (id int, skew time.Duration, ok bool)
+}
+
+// base holds the bookkeeping shared by every Generator: a wall-clock start
+// time and the running id counter.
+type base struct {
+	start time.Time
+	id    int
+}
+
+// arriveAt sleeps until start+offset (a no-op if that instant has already
+// passed) and returns how late the call arrived relative to schedule; zero
+// means the generator is keeping up, positive means it has
+// fallen behind and the caller should not trust this sample's latency.
+func (b *base) arriveAt(offset time.Duration) time.Duration {
+	target := b.start.Add(offset)
+	now := time.Now()
+	if target.After(now) {
+		time.Sleep(target.Sub(now))
+		return 0
+	}
+	// Negative sleep_duration: the generator has already fallen behind
+	// schedule. Dispatch immediately instead of silently coalescing the
+	// backlog, and surface the lag to the caller.
+	return now.Sub(target)
+}
+
+// ConstantRate is an open-loop, closed-interval generator: one arrival
+// every 1/rate seconds for duration seconds. This is the existing
+// test_time/benchmark_step_warm_lat behavior, lifted into a Generator.
+type ConstantRate struct {
+	base
+	Rate        int
+	DurationSec float64
+}
+
+// NewConstantRate starts the clock now; rate is requests/second.
+func NewConstantRate(rate int, durationSec float64) *ConstantRate {
+	return &ConstantRate{base: base{start: time.Now()}, Rate: rate, DurationSec: durationSec}
+}
+
+func (g *ConstantRate) Next() (int, time.Duration, bool) {
+	interArrival := 1.0 / float64(g.Rate)
+	offset := time.Duration(float64(g.id) * interArrival * float64(time.Second))
+	if offset.Seconds() >= g.DurationSec {
+		return 0, 0, false
+	}
+	skew := g.arriveAt(offset)
+	id := g.id
+	g.id++
+	return id, skew, true
+}
+
+// Poisson draws inter-arrivals from -ln(U)/lambda, the standard way to
+// sample a Poisson arrival process, so bursts look like real open-loop
+// traffic instead of a metronome.
+type Poisson struct {
+	base
+	Lambda      float64
+	DurationSec float64
+	Rand        *rand.Rand
+
+	nextOffset time.Duration
+}
+
+// NewPoisson seeds its own rand.Source from src so runs are reproducible
+// when the caller passes a fixed seed.
+func NewPoisson(lambda, durationSec float64, src rand.Source) *Poisson {
+	return &Poisson{base: base{start: time.Now()}, Lambda: lambda, DurationSec: durationSec, Rand: rand.New(src)}
+}
+
+func (g *Poisson) Next() (int, time.Duration, bool) {
+	if g.nextOffset.Seconds() >= g.DurationSec {
+		return 0, 0, false
+	}
+	interArrival := -math.Log(g.Rand.Float64()) / g.Lambda
+	skew := g.arriveAt(g.nextOffset)
+	id := g.id
+	g.id++
+	g.nextOffset += time.Duration(interArrival * float64(time.Second))
+	return id, skew, true
+}
+
+// TraceEntry is one scheduled dispatch read from a trace file: OffsetUs is
+// relative to the start of the run, RequestBodyPath points at the request
+// payload to send for this entry.
+type TraceEntry struct {
+	OffsetUs        int64
+	RequestBodyPath string
+}
+
+// TraceReplay dispatches at the wall-clock offsets recorded in a trace
+// (e.g. captured from a production access log), rather than a synthetic
+// distribution.
+type TraceReplay struct {
+	base
+	Entries []TraceEntry
+}
+
+// NewTraceReplay starts the clock now; entries should already be sorted
+// by OffsetUs.
+func NewTraceReplay(entries []TraceEntry) *TraceReplay {
+	return &TraceReplay{base: base{start: time.Now()}, Entries: entries}
+}
+
+// LoadTrace reads a trace of (offset_us, request_body_path) pairs from
+// path, dispatching to LoadTraceCSV or LoadTraceJSON by extension.
+func LoadTrace(path string) ([]TraceEntry, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return LoadTraceJSON(path)
+	case ".csv":
+		return LoadTraceCSV(path)
+	default:
+		return nil, fmt.Errorf("workload: unrecognized trace extension for %v, want .csv or .json", path)
+	}
+}
+
+// LoadTraceCSV reads a trace file with rows "offset_us,request_body_path"
+// and no header.
+func LoadTraceCSV(path string) ([]TraceEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TraceEntry, 0, len(rows))
+	for i, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("workload: %v:%v: want 2 columns, got %v", path, i+1, len(row))
+		}
+		offsetUs, err := strconv.ParseInt(strings.TrimSpace(row[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("workload: %v:%v: bad offset_us: %w", path, i+1, err)
+		}
+		entries = append(entries, TraceEntry{OffsetUs: offsetUs, RequestBodyPath: strings.TrimSpace(row[1])})
+	}
+	return entries, nil
+}
+
+// LoadTraceJSON reads a trace file holding a JSON array of
+// {"offset_us": ..., "request_body_path": ...} objects.
+func LoadTraceJSON(path string) ([]TraceEntry, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		OffsetUs        int64  `json:"offset_us"`
+		RequestBodyPath string `json:"request_body_path"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	entries := make([]TraceEntry, len(raw))
+	for i, e := range raw {
+		entries[i] = TraceEntry{OffsetUs: e.OffsetUs, RequestBodyPath: e.RequestBodyPath}
+	}
+	return entries, nil
+}
+
+func (g *TraceReplay) Next() (int, time.Duration, bool) {
+	if g.id >= len(g.Entries) {
+		return 0, 0, false
+	}
+	skew := g.arriveAt(time.Duration(g.Entries[g.id].OffsetUs) * time.Microsecond)
+	id := g.id
+	g.id++
+	return id, skew, true
+}
+
+// RequestBodyPath returns the payload path recorded for id, the companion
+// lookup callers need since Next only returns an id.
+func (g *TraceReplay) RequestBodyPath(id int) string {
+	return g.Entries[id].RequestBodyPath
+}
+
+// Step is one segment of a StepFunction script: lambda holds for
+// durationSec before the generator advances to the next step.
+type Step struct {
+	Lambda      float64
+	DurationSec float64
+}
+
+// StepFunction ramps the arrival rate lambda over time according to a
+// script of Steps, each driven by the same -ln(U)/lambda draw as Poisson.
+type StepFunction struct {
+	base
+	Steps []Step
+	Rand  *rand.Rand
+
+	stepIdx      int
+	stepStartSec float64
+	nextOffset   time.Duration
+}
+
+// NewStepFunction seeds its own rand.Source from src so runs are reproducible.
+func NewStepFunction(steps []Step, src rand.Source) *StepFunction {
+	return &StepFunction{base: base{start: time.Now()}, Steps: steps, Rand: rand.New(src)}
+}
+
+func (g *StepFunction) Next() (int, time.Duration, bool) {
+	for g.stepIdx < len(g.Steps) && g.nextOffset.Seconds()-g.stepStartSec >= g.Steps[g.stepIdx].DurationSec {
+		g.stepStartSec += g.Steps[g.stepIdx].DurationSec
+		g.stepIdx++
+	}
+	if g.stepIdx >= len(g.Steps) {
+		return 0, 0, false
+	}
+	lambda := g.Steps[g.stepIdx].Lambda
+	interArrival := -math.Log(g.Rand.Float64()) / lambda
+	skew := g.arriveAt(g.nextOffset)
+	id := g.id
+	g.id++
+	g.nextOffset += time.Duration(interArrival * float64(time.Second))
+	return id, skew, true
+}